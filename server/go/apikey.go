@@ -0,0 +1,310 @@
+package signpay
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// APIKeyEntry describes a single API key and the restrictions that apply to it.
+type APIKeyEntry struct {
+	Key             string
+	UserID          string
+	Disable         bool
+	RateLimit       rate.Limit // requests per second; zero means unlimited
+	RateBurst       int        // burst size for RateLimit; defaults to 1 if unset
+	DomainWhitelist []string   // allowed Origin/Host values; empty means any
+	IPWhitelist     []string   // allowed client IPs; empty means any
+}
+
+// RateLimiter is implemented by an APIKeyStore that wants checkAPIKey to
+// enforce per-key rate limiting. A store that doesn't implement it (e.g. a
+// custom store backed by an external database) gets no rate limiting from
+// the middleware even if its entries set RateLimit - implement this
+// alongside APIKeyStore to opt in. InMemoryAPIKeyStore implements it.
+type RateLimiter interface {
+	// Limiter returns the rate.Limiter for entry, or nil if it is unlimited.
+	Limiter(entry *APIKeyEntry) *rate.Limiter
+}
+
+// APIKeyStore is the persistence contract for API keys. Implementations may be
+// in-memory (for development) or backed by a real datastore. Implement
+// RateLimiter too if entries should be rate limited.
+type APIKeyStore interface {
+	// Get returns the entry for key, or nil if it does not exist.
+	Get(key string) (*APIKeyEntry, error)
+	// Create stores a new entry, replacing any existing entry with the same key.
+	Create(entry *APIKeyEntry) error
+	// Disable marks key as disabled so it is rejected by the middleware.
+	Disable(key string) error
+	// Rotate replaces oldKey with a newly generated key that keeps the same
+	// restrictions, and returns the new entry. oldKey is disabled in the process.
+	Rotate(oldKey string) (*APIKeyEntry, error)
+}
+
+// InMemoryAPIKeyStore is an APIKeyStore backed by a process-local map. It is
+// suitable for development and testing; production deployments should provide
+// a store backed by durable storage that satisfies the same interface.
+type InMemoryAPIKeyStore struct {
+	mu       sync.RWMutex
+	entries  map[string]*APIKeyEntry
+	limiters map[string]*rate.Limiter
+}
+
+// NewInMemoryAPIKeyStore returns an empty InMemoryAPIKeyStore.
+func NewInMemoryAPIKeyStore() *InMemoryAPIKeyStore {
+	return &InMemoryAPIKeyStore{
+		entries:  make(map[string]*APIKeyEntry),
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+func (s *InMemoryAPIKeyStore) Get(key string) (*APIKeyEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	return entry, nil
+}
+
+func (s *InMemoryAPIKeyStore) Create(entry *APIKeyEntry) error {
+	if entry.Key == "" {
+		return fmt.Errorf("api key entry must have a non-empty key")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[entry.Key] = entry
+	delete(s.limiters, entry.Key)
+	return nil
+}
+
+func (s *InMemoryAPIKeyStore) Disable(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		return fmt.Errorf("api key %q not found", key)
+	}
+	entry.Disable = true
+	return nil
+}
+
+func (s *InMemoryAPIKeyStore) Rotate(oldKey string) (*APIKeyEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[oldKey]
+	if !ok {
+		return nil, fmt.Errorf("api key %q not found", oldKey)
+	}
+	entry.Disable = true
+
+	newEntry := &APIKeyEntry{
+		Key:             generateAPIKey(),
+		UserID:          entry.UserID,
+		RateLimit:       entry.RateLimit,
+		RateBurst:       entry.RateBurst,
+		DomainWhitelist: entry.DomainWhitelist,
+		IPWhitelist:     entry.IPWhitelist,
+	}
+	s.entries[newEntry.Key] = newEntry
+	return newEntry, nil
+}
+
+// Limiter returns the rate.Limiter for entry, creating one lazily from its
+// configured RateLimit the first time the key is seen. It satisfies
+// RateLimiter.
+func (s *InMemoryAPIKeyStore) Limiter(entry *APIKeyEntry) *rate.Limiter {
+	if entry.RateLimit <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	limiter, ok := s.limiters[entry.Key]
+	if !ok {
+		burst := entry.RateBurst
+		if burst <= 0 {
+			burst = 1
+		}
+		limiter = rate.NewLimiter(entry.RateLimit, burst)
+		s.limiters[entry.Key] = limiter
+	}
+	return limiter
+}
+
+// generateAPIKey returns a new API key consisting of the prefix "sk_"
+// followed by 32 bytes of crypto/rand-sourced entropy, hex-encoded. An API-key subsystem exists to
+// protect endpoints, so keys must be unguessable and unique, not merely
+// distinct.
+func generateAPIKey() string {
+	var buf [32]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic("signpay: failed to generate API key: " + err.Error())
+	}
+	return "sk_" + hex.EncodeToString(buf[:])
+}
+
+// apiKeyFromHeaders extracts the caller's API key from the X-API-Key header
+// value, falling back to an "Authorization: Bearer <key>" header value. It is
+// framework-neutral so every adapter (Gin, net/http, Echo, gRPC) extracts the
+// key identically.
+func apiKeyFromHeaders(xAPIKey, authorization string) string {
+	if xAPIKey != "" {
+		return xAPIKey
+	}
+	if strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimPrefix(authorization, "Bearer ")
+	}
+	return ""
+}
+
+// apiKeyFromRequest extracts the caller's API key from the X-API-Key header,
+// falling back to an "Authorization: Bearer <key>" header.
+func apiKeyFromRequest(c *gin.Context) string {
+	return apiKeyFromHeaders(c.GetHeader("X-API-Key"), c.GetHeader("Authorization"))
+}
+
+// checkAPIKeyRequest validates apiKey against store, returning the
+// authenticated entry's UserID, or a PaymentError describing the response an
+// adapter should send if validation fails. It is framework-neutral so every
+// adapter enforces WithAPIKeyStore (including rate limiting and domain/IP
+// allowlists) identically instead of only the Gin middleware doing so.
+func checkAPIKeyRequest(store APIKeyStore, apiKey, host, origin, clientIP string) (string, *PaymentError) {
+	if apiKey == "" {
+		return "", &PaymentError{StatusCode: http.StatusUnauthorized, Message: "X-API-Key header is required"}
+	}
+
+	entry, err := store.Get(apiKey)
+	if err != nil {
+		return "", &PaymentError{StatusCode: http.StatusInternalServerError, Message: "Failed to look up API key: " + err.Error()}
+	}
+	if entry == nil || entry.Disable {
+		return "", &PaymentError{StatusCode: http.StatusUnauthorized, Message: "API key is invalid or disabled"}
+	}
+
+	if !domainAllowed(entry.DomainWhitelist, host, origin) {
+		return "", &PaymentError{StatusCode: http.StatusForbidden, Message: "API key is not authorized for this domain"}
+	}
+	if !ipAllowed(entry.IPWhitelist, clientIP) {
+		return "", &PaymentError{StatusCode: http.StatusForbidden, Message: "API key is not authorized for this IP address"}
+	}
+
+	if limited, ok := store.(RateLimiter); ok {
+		if limiter := limited.Limiter(entry); limiter != nil && !limiter.Allow() {
+			return "", &PaymentError{StatusCode: http.StatusTooManyRequests, Message: "API key rate limit exceeded"}
+		}
+	}
+
+	return entry.UserID, nil
+}
+
+// checkAPIKey validates the caller's API key against store and aborts the
+// request with the appropriate status code if it fails any check. It returns
+// true if the request may proceed.
+func checkAPIKey(c *gin.Context, store APIKeyStore) bool {
+	userID, paymentErr := checkAPIKeyRequest(store, apiKeyFromRequest(c), c.Request.Host, c.GetHeader("Origin"), c.ClientIP())
+	if paymentErr != nil {
+		c.AbortWithStatusJSON(paymentErr.StatusCode, gin.H{
+			"error":       paymentErr.Message,
+			"x402Version": x402Version,
+		})
+		return false
+	}
+	c.Set(APIKeyUserIDKey, userID)
+	return true
+}
+
+func domainAllowed(whitelist []string, host, origin string) bool {
+	if len(whitelist) == 0 {
+		return true
+	}
+	for _, allowed := range whitelist {
+		if allowed == host || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func ipAllowed(whitelist []string, ip string) bool {
+	if len(whitelist) == 0 {
+		return true
+	}
+	for _, allowed := range whitelist {
+		if allowed == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyUserIDKey is the Gin context key under which the authenticated
+// caller's UserID is stored once its API key passes validation.
+const APIKeyUserIDKey = "signPayAPIKeyUserID"
+
+// WithAPIKeyStore configures the middleware to require and validate an
+// X-API-Key (or Authorization: Bearer) header against store before the
+// facilitator is contacted.
+func WithAPIKeyStore(store APIKeyStore) Option {
+	return func(o *Options) {
+		o.APIKeyStore = store
+	}
+}
+
+// RegisterAdminRoutes registers handlers under /signpay/admin/keys for
+// creating, disabling, and rotating API keys backed by store.
+func RegisterAdminRoutes(r *gin.Engine, store APIKeyStore) {
+	group := r.Group("/signpay/admin/keys")
+
+	group.POST("", func(c *gin.Context) {
+		var req struct {
+			UserID          string   `json:"userId"`
+			RateLimit       float64  `json:"rateLimit"`
+			RateBurst       int      `json:"rateBurst"`
+			DomainWhitelist []string `json:"domainWhitelist"`
+			IPWhitelist     []string `json:"ipWhitelist"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+			return
+		}
+
+		entry := &APIKeyEntry{
+			Key:             generateAPIKey(),
+			UserID:          req.UserID,
+			RateLimit:       rate.Limit(req.RateLimit),
+			RateBurst:       req.RateBurst,
+			DomainWhitelist: req.DomainWhitelist,
+			IPWhitelist:     req.IPWhitelist,
+		}
+		if err := store.Create(entry); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, entry)
+	})
+
+	group.POST("/:key/disable", func(c *gin.Context) {
+		if err := store.Disable(c.Param("key")); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"disabled": true})
+	})
+
+	group.POST("/:key/rotate", func(c *gin.Context) {
+		entry, err := store.Rotate(c.Param("key"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, entry)
+	})
+}
@@ -0,0 +1,68 @@
+package signpay
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/labstack/echo/v4"
+)
+
+// EchoMiddleware adapts Verifier to Echo's middleware signature. On success,
+// the verified PaymentData is stored on the Echo context under
+// PaymentDataKey and retrievable via
+// c.Get(signpay.PaymentDataKey).(*signpay.PaymentData).
+func EchoMiddleware(verifier *Verifier) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+
+			if verifier.options.APIKeyStore != nil {
+				apiKey := apiKeyFromHeaders(req.Header.Get("X-API-Key"), req.Header.Get("Authorization"))
+				userID, paymentErr := checkAPIKeyRequest(verifier.options.APIKeyStore, apiKey, req.Host, req.Header.Get("Origin"), clientIPFromRequest(req))
+				if paymentErr != nil {
+					body := map[string]interface{}{"error": paymentErr.Message, "x402Version": x402Version}
+					return c.JSON(paymentErr.StatusCode, body)
+				}
+				c.Set(APIKeyUserIDKey, userID)
+			}
+
+			idempotencyKey := req.Header.Get("Idempotency-Key")
+
+			var requestBody json.RawMessage
+			if req.Body != nil {
+				bodyBytes, err := io.ReadAll(req.Body)
+				if err != nil {
+					return echo.NewHTTPError(400, "Failed to read request body")
+				}
+				req.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+				if len(bodyBytes) > 0 {
+					requestBody = json.RawMessage(bodyBytes)
+				}
+			}
+
+			in := RequirementsInput{
+				Method: req.Method,
+				Path:   req.URL.Path,
+				Host:   req.Host,
+				TLS:    req.TLS != nil,
+			}
+
+			paymentData, paymentErr := verifier.HandlePayment(req.Context(), in, req.Header.Get("X-PAYMENT"), requestBody, idempotencyKey)
+			if paymentErr != nil {
+				body := map[string]interface{}{"error": paymentErr.Message, "x402Version": x402Version}
+				if paymentErr.Accepts != nil {
+					body["accepts"] = paymentErr.Accepts
+				}
+				return c.JSON(paymentErr.StatusCode, body)
+			}
+
+			if header, err := verifier.EncodeResponseHeader(paymentData); err == nil {
+				c.Response().Header().Set("X-PAYMENT-RESPONSE", header)
+			}
+
+			c.Set(PaymentDataKey, paymentData)
+			return next(c)
+		}
+	}
+}
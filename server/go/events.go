@@ -0,0 +1,249 @@
+package signpay
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType identifies a point in the settlement lifecycle.
+type EventType string
+
+const (
+	EventPaymentRequired EventType = "payment.required"
+	EventPaymentVerified EventType = "payment.verified"
+	EventPaymentSettled  EventType = "payment.settled"
+	EventPaymentFailed   EventType = "payment.failed"
+	EventPaymentReplayed EventType = "payment.replayed"
+)
+
+// Event describes something that happened while processing a payment, for
+// consumption by systems outside the Gin handler chain (order DB, email,
+// inventory, analytics).
+type Event struct {
+	Type        EventType
+	Sequence    uint64
+	PaymentData *PaymentData
+	Method      string // request method, e.g. "POST"
+	Path        string // request URL path
+	Host        string // request Host header
+	Error       string `json:",omitempty"` // populated for EventPaymentFailed
+	Timestamp   time.Time
+}
+
+// EventSink receives settlement lifecycle events. Emit must not block the
+// request path for long; implementations that talk to the network (e.g.
+// WebhookEventSink) should queue and deliver asynchronously.
+type EventSink interface {
+	Emit(ctx context.Context, event Event)
+}
+
+var eventSequence uint64
+
+func nextEventSequence() uint64 {
+	return atomic.AddUint64(&eventSequence, 1)
+}
+
+// ChannelEventSink publishes events on a Go channel for in-process
+// consumers. Emit never blocks: if the channel is full, the event is
+// dropped and logged.
+type ChannelEventSink struct {
+	ch chan Event
+}
+
+// NewChannelEventSink returns a ChannelEventSink buffering up to buffer
+// events before Emit starts dropping them.
+func NewChannelEventSink(buffer int) *ChannelEventSink {
+	return &ChannelEventSink{ch: make(chan Event, buffer)}
+}
+
+// Events returns the channel new events are published on.
+func (s *ChannelEventSink) Events() <-chan Event {
+	return s.ch
+}
+
+func (s *ChannelEventSink) Emit(ctx context.Context, event Event) {
+	select {
+	case s.ch <- event:
+	default:
+		fmt.Printf("[SignPay] event channel full, dropping %s event\n", event.Type)
+	}
+}
+
+// webhookMaxAttempts bounds the number of delivery attempts (the first send
+// plus its exponential-backoff retries) a WebhookEventSink makes before
+// giving up on an event.
+const webhookMaxAttempts = 5
+
+// webhookInitialBackoff is the delay before the first retry; it doubles on
+// every subsequent attempt.
+const webhookInitialBackoff = time.Second
+
+// WebhookEventSink POSTs events as signed JSON to a merchant-configured URL.
+// Deliveries are queued in a bounded in-memory buffer and retried with
+// exponential backoff; if the queue is full, Emit drops the new event being
+// emitted (the events already queued are left to drain) rather than
+// blocking the request path.
+type WebhookEventSink struct {
+	url    string
+	secret string
+	client *http.Client
+
+	queue chan Event
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewWebhookEventSink returns a WebhookEventSink that POSTs to url, signing
+// each request body with secret, and buffering up to queueSize
+// not-yet-delivered events.
+func NewWebhookEventSink(url, secret string, queueSize int) *WebhookEventSink {
+	s := &WebhookEventSink{
+		url:    url,
+		secret: secret,
+		client: http.DefaultClient,
+		queue:  make(chan Event, queueSize),
+		done:   make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.loop()
+	return s
+}
+
+// Close stops the delivery loop. Queued events that have not yet been
+// delivered are discarded.
+func (s *WebhookEventSink) Close() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+func (s *WebhookEventSink) Emit(ctx context.Context, event Event) {
+	select {
+	case s.queue <- event:
+	default:
+		fmt.Printf("[SignPay] webhook queue full, dropping %s event\n", event.Type)
+	}
+}
+
+func (s *WebhookEventSink) loop() {
+	defer s.wg.Done()
+	for {
+		select {
+		case event := <-s.queue:
+			s.deliver(event)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *WebhookEventSink) deliver(event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		fmt.Printf("[SignPay] failed to marshal %s event: %v\n", event.Type, err)
+		return
+	}
+
+	backoff := webhookInitialBackoff
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		if err := s.send(body); err == nil {
+			return
+		} else if attempt == webhookMaxAttempts {
+			fmt.Printf("[SignPay] giving up delivering %s event after %d attempts: %v\n", event.Type, attempt, err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (s *WebhookEventSink) send(body []byte) error {
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-SignPay-Signature", signWebhookPayload(s.secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// signWebhookPayload produces the value of the X-SignPay-Signature header:
+// "t=<unix timestamp>,v1=<hex HMAC-SHA256 of \"<timestamp>.<body>\">".
+func signWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// VerifyWebhookSignature checks a received X-SignPay-Signature header
+// against body, rejecting it if the signature doesn't match or its
+// timestamp is older than tolerance. Merchants receiving webhook deliveries
+// should call this before trusting a payload.
+func VerifyWebhookSignature(secret, header string, body []byte, tolerance time.Duration) error {
+	var timestamp int64
+	var signature string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			ts, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid signature timestamp: %w", err)
+			}
+			timestamp = ts
+		case "v1":
+			signature = kv[1]
+		}
+	}
+	if timestamp == 0 || signature == "" {
+		return fmt.Errorf("malformed signature header")
+	}
+
+	if time.Since(time.Unix(timestamp, 0)) > tolerance {
+		return fmt.Errorf("signature timestamp is outside the %s tolerance window", tolerance)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return fmt.Errorf("signature does not match")
+	}
+	return nil
+}
+
+// WithEventSink configures the middleware to emit settlement lifecycle
+// events to sink at each stage of processing, even when the request aborts.
+// Requests rejected by the WithAPIKeyStore check never reach payment
+// processing and emit no event.
+func WithEventSink(sink EventSink) Option {
+	return func(o *Options) {
+		o.EventSink = sink
+	}
+}
@@ -0,0 +1,128 @@
+package signpay
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// paymentDataGRPCKey is the context key UnaryServerInterceptor stores
+// verified PaymentData under.
+type paymentDataGRPCKey struct{}
+
+// apiKeyUserIDGRPCKey is the context key UnaryServerInterceptor stores the
+// validated API key's UserID under.
+type apiKeyUserIDGRPCKey struct{}
+
+// UnaryServerInterceptor adapts Verifier to a gRPC unary interceptor. It
+// reads the caller's payment from the "x-payment" metadata entry and, on
+// success, writes the settlement response back as "x-payment-response"
+// trailer metadata and stores PaymentData in the handler's context.
+func UnaryServerInterceptor(verifier *Verifier) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+
+		if verifier.options.APIKeyStore != nil {
+			var apiKey, authorization, origin string
+			if values := md.Get("x-api-key"); len(values) > 0 {
+				apiKey = values[0]
+			}
+			if values := md.Get("authorization"); len(values) > 0 {
+				authorization = values[0]
+			}
+			if values := md.Get("origin"); len(values) > 0 {
+				origin = values[0]
+			}
+			var host string
+			if authority := md.Get(":authority"); len(authority) > 0 {
+				host = authority[0]
+			}
+
+			userID, paymentErr := checkAPIKeyRequest(verifier.options.APIKeyStore, apiKeyFromHeaders(apiKey, authorization), host, origin, clientIPFromGRPCContext(ctx))
+			if paymentErr != nil {
+				return nil, status.Error(grpcStatusCode(paymentErr.StatusCode), paymentErr.Message)
+			}
+			ctx = context.WithValue(ctx, apiKeyUserIDGRPCKey{}, userID)
+		}
+
+		in := RequirementsInput{
+			Method: info.FullMethod,
+			Path:   info.FullMethod,
+		}
+		if authority := md.Get(":authority"); len(authority) > 0 {
+			in.Host = authority[0]
+		}
+
+		var paymentHeader string
+		if values := md.Get("x-payment"); len(values) > 0 {
+			paymentHeader = values[0]
+		}
+
+		paymentData, paymentErr := verifier.HandlePayment(ctx, in, paymentHeader, nil, "")
+		if paymentErr != nil {
+			return nil, status.Error(grpcStatusCode(paymentErr.StatusCode), paymentErr.Message)
+		}
+
+		if header, err := verifier.EncodeResponseHeader(paymentData); err == nil {
+			grpc.SetTrailer(ctx, metadata.Pairs("x-payment-response", header))
+		}
+
+		ctx = context.WithValue(ctx, paymentDataGRPCKey{}, paymentData)
+		return handler(ctx, req)
+	}
+}
+
+// PaymentDataFromGRPCContext retrieves verified payment data stored by
+// UnaryServerInterceptor.
+func PaymentDataFromGRPCContext(ctx context.Context) *PaymentData {
+	data, _ := ctx.Value(paymentDataGRPCKey{}).(*PaymentData)
+	return data
+}
+
+// APIKeyUserIDFromGRPCContext retrieves the UserID of the API key
+// UnaryServerInterceptor validated, if WithAPIKeyStore is configured.
+func APIKeyUserIDFromGRPCContext(ctx context.Context) string {
+	userID, _ := ctx.Value(apiKeyUserIDGRPCKey{}).(string)
+	return userID
+}
+
+// clientIPFromGRPCContext returns the caller's IP for use against an
+// APIKeyEntry's IPWhitelist, extracted from the peer info gRPC attaches to
+// every incoming call's context.
+func clientIPFromGRPCContext(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// grpcStatusCode maps the HTTP status codes PaymentError uses onto the
+// closest gRPC status code.
+func grpcStatusCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case 400:
+		return codes.InvalidArgument
+	case 401:
+		return codes.Unauthenticated
+	case 402:
+		return codes.FailedPrecondition
+	case 403:
+		return codes.PermissionDenied
+	case 409:
+		return codes.Aborted
+	case 429:
+		return codes.ResourceExhausted
+	default:
+		return codes.Internal
+	}
+}
@@ -0,0 +1,101 @@
+package signpay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+)
+
+type contextKey string
+
+const paymentDataContextKey contextKey = "signPaymentData"
+const apiKeyUserIDContextKey contextKey = "signPayAPIKeyUserID"
+
+// Handler wraps next with net/http middleware performing the same
+// signature-based payment verification and settlement as SignPayMiddleware,
+// for applications not built on Gin. On success, the verified PaymentData is
+// stored in the request context and retrievable via PaymentDataFromContext.
+func Handler(verifier *Verifier, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if verifier.options.APIKeyStore != nil {
+			apiKey := apiKeyFromHeaders(r.Header.Get("X-API-Key"), r.Header.Get("Authorization"))
+			userID, paymentErr := checkAPIKeyRequest(verifier.options.APIKeyStore, apiKey, r.Host, r.Header.Get("Origin"), clientIPFromRequest(r))
+			if paymentErr != nil {
+				writePaymentError(w, paymentErr)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), apiKeyUserIDContextKey, userID))
+		}
+
+		idempotencyKey := r.Header.Get("Idempotency-Key")
+
+		var requestBody json.RawMessage
+		if r.Body != nil {
+			bodyBytes, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "Failed to read request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+			if len(bodyBytes) > 0 {
+				requestBody = json.RawMessage(bodyBytes)
+			}
+		}
+
+		in := RequirementsInput{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Host:   r.Host,
+			TLS:    r.TLS != nil,
+		}
+
+		paymentData, paymentErr := verifier.HandlePayment(r.Context(), in, r.Header.Get("X-PAYMENT"), requestBody, idempotencyKey)
+		if paymentErr != nil {
+			writePaymentError(w, paymentErr)
+			return
+		}
+
+		if header, err := verifier.EncodeResponseHeader(paymentData); err == nil {
+			w.Header().Set("X-PAYMENT-RESPONSE", header)
+		}
+
+		ctx := context.WithValue(r.Context(), paymentDataContextKey, paymentData)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// PaymentDataFromContext retrieves verified payment data stored by Handler.
+func PaymentDataFromContext(ctx context.Context) *PaymentData {
+	data, _ := ctx.Value(paymentDataContextKey).(*PaymentData)
+	return data
+}
+
+// APIKeyUserIDFromContext retrieves the UserID of the API key Handler
+// validated, if WithAPIKeyStore is configured.
+func APIKeyUserIDFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value(apiKeyUserIDContextKey).(string)
+	return userID
+}
+
+// clientIPFromRequest returns the host portion of r.RemoteAddr for use
+// against an APIKeyEntry's IPWhitelist.
+func clientIPFromRequest(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func writePaymentError(w http.ResponseWriter, paymentErr *PaymentError) {
+	body := map[string]interface{}{"error": paymentErr.Message, "x402Version": x402Version}
+	if paymentErr.Accepts != nil {
+		body["accepts"] = paymentErr.Accepts
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(paymentErr.StatusCode)
+	json.NewEncoder(w).Encode(body)
+}
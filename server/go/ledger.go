@@ -0,0 +1,277 @@
+package signpay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coinbase/x402/go/pkg/facilitatorclient"
+	"github.com/coinbase/x402/go/pkg/types"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrRefundConflict is returned by a LedgerStore's UpdateRefund when the
+// payment's recorded refund state changed between the caller's read and its
+// write, e.g. a second refund request for the same payment committed first.
+var ErrRefundConflict = errors.New("refund was not recorded: payment's refund state changed concurrently")
+
+// RefundState tracks how much of a settled payment has been reversed.
+type RefundState string
+
+const (
+	RefundStateNone    RefundState = "none"
+	RefundStatePartial RefundState = "partial"
+	RefundStateFull    RefundState = "full"
+)
+
+// LedgerEntry is a single settled payment recorded for historical lookup
+// and refunds.
+type LedgerEntry struct {
+	Transaction    string // SettleResponse.Transaction; primary key
+	Payer          string
+	Payee          string
+	Asset          string
+	Network        string
+	GrossAmount    string
+	Timestamp      time.Time
+	RequestBody    json.RawMessage
+	RefundState    RefundState
+	RefundedAmount string
+	RefundReason   string
+}
+
+// LedgerStore persists LedgerEntry rows.
+type LedgerStore interface {
+	Record(ctx context.Context, entry *LedgerEntry) error
+	Get(ctx context.Context, transaction string) (*LedgerEntry, error)
+	List(ctx context.Context) ([]*LedgerEntry, error)
+	// UpdateRefund persists a refund outcome, succeeding only if the stored
+	// refunded_amount for transaction still equals expectedRefundedAmount
+	// (optimistic concurrency control keyed off the value Ledger.Refund
+	// based its validation on), so two concurrent refunds for the same
+	// payment can't both commit from a stale read. It returns
+	// ErrRefundConflict if expectedRefundedAmount is stale.
+	UpdateRefund(ctx context.Context, transaction string, expectedRefundedAmount string, newRefundedAmount string, state RefundState, reason string) error
+}
+
+// RefundSigner produces a signed ERC-3009 transfer payload that satisfies
+// requirements, used to fund a refund. A typical implementation holds the
+// merchant's receiving wallet key and signs a transfer back to the original
+// payer.
+type RefundSigner interface {
+	SignTransfer(ctx context.Context, requirements *types.PaymentRequirements) (*types.PaymentPayload, error)
+}
+
+// Ledger records settled payments and submits refunds for them through the
+// same facilitator used for the original settlement.
+type Ledger struct {
+	store             LedgerStore
+	facilitatorClient *facilitatorclient.FacilitatorClient
+	signer            RefundSigner
+
+	refundLocksMu sync.Mutex
+	refundLocks   map[string]*sync.Mutex // one per transaction currently being refunded
+}
+
+// NewLedger returns a Ledger backed by store, submitting refunds via
+// facilitatorClient using signer to produce the reverse transfer payload.
+func NewLedger(store LedgerStore, facilitatorClient *facilitatorclient.FacilitatorClient, signer RefundSigner) *Ledger {
+	return &Ledger{store: store, facilitatorClient: facilitatorClient, signer: signer}
+}
+
+// lockTransaction serializes Refund calls for the same transaction within
+// this process, so two concurrent requests can't both read the same
+// refunded_amount, both pass validation, and both submit a real reverse
+// transfer through the facilitator before either persists. Call the
+// returned func to release the lock. This only protects a single process;
+// store.UpdateRefund's compare-and-set is what protects multi-instance
+// deployments sharing one LedgerStore.
+func (l *Ledger) lockTransaction(transaction string) func() {
+	l.refundLocksMu.Lock()
+	if l.refundLocks == nil {
+		l.refundLocks = make(map[string]*sync.Mutex)
+	}
+	txLock, ok := l.refundLocks[transaction]
+	if !ok {
+		txLock = &sync.Mutex{}
+		l.refundLocks[transaction] = txLock
+	}
+	l.refundLocksMu.Unlock()
+
+	txLock.Lock()
+	return txLock.Unlock
+}
+
+// Record stores data as a new ledger entry. It is called automatically by
+// SignPayMiddleware when WithLedger is configured.
+func (l *Ledger) Record(ctx context.Context, data *PaymentData) error {
+	return l.store.Record(ctx, &LedgerEntry{
+		Transaction: data.SettleResponse.Transaction,
+		Payer:       data.PaymentPayload.Payload.Authorization.From,
+		Payee:       data.PaymentRequirements.PayTo,
+		Asset:       data.PaymentRequirements.Asset,
+		Network:     data.PaymentRequirements.Network,
+		GrossAmount: data.PaymentRequirements.MaxAmountRequired,
+		Timestamp:   time.Now(),
+		RequestBody: data.RequestBody,
+		RefundState: RefundStateNone,
+	})
+}
+
+// Refund reverses amount of a previously settled payment identified by
+// transaction, submitting a reverse exact-scheme transfer through the
+// facilitator and recording the outcome. It rejects a payment that has
+// already been fully refunded, and rejects any refund that together with
+// prior refunds would exceed the original gross amount. Concurrent calls
+// for the same transaction are serialized so they can't both validate
+// against the same stale refunded_amount and both settle for real.
+func (l *Ledger) Refund(ctx context.Context, transaction string, amount string, reason string) (*LedgerEntry, error) {
+	unlock := l.lockTransaction(transaction)
+	defer unlock()
+
+	entry, err := l.store.Get(ctx, transaction)
+	if err != nil {
+		return nil, fmt.Errorf("look up payment: %w", err)
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("payment %q not found", transaction)
+	}
+	if entry.RefundState == RefundStateFull {
+		return nil, fmt.Errorf("payment %q has already been fully refunded", transaction)
+	}
+
+	cumulative, err := cumulativeRefundAmount(entry, amount)
+	if err != nil {
+		return nil, err
+	}
+	expectedRefundedAmount := entry.RefundedAmount
+
+	refundRequirements := &types.PaymentRequirements{
+		Scheme:            "exact",
+		Network:           entry.Network,
+		MaxAmountRequired: amount,
+		Description:       "Refund for " + transaction,
+		PayTo:             entry.Payer,
+		Asset:             entry.Asset,
+		MaxTimeoutSeconds: 300,
+	}
+
+	payload, err := l.signer.SignTransfer(ctx, refundRequirements)
+	if err != nil {
+		return nil, fmt.Errorf("sign refund transfer: %w", err)
+	}
+
+	settleResponse, err := l.facilitatorClient.Settle(payload, refundRequirements)
+	if err != nil {
+		return nil, fmt.Errorf("settle refund: %w", err)
+	}
+	if !settleResponse.Success {
+		errorReason := "refund settlement was not successful"
+		if settleResponse.ErrorReason != nil {
+			errorReason = *settleResponse.ErrorReason
+		}
+		return nil, fmt.Errorf("%s", errorReason)
+	}
+
+	state := RefundStatePartial
+	if cumulative.gross.Cmp(cumulative.total) == 0 {
+		state = RefundStateFull
+	}
+	cumulativeAmount := cumulative.total.String()
+	if err := l.store.UpdateRefund(ctx, transaction, expectedRefundedAmount, cumulativeAmount, state, reason); err != nil {
+		return nil, fmt.Errorf("record refund: %w", err)
+	}
+
+	entry.RefundState = state
+	entry.RefundedAmount = cumulativeAmount
+	entry.RefundReason = reason
+	return entry, nil
+}
+
+// cumulativeTotal is the result of validating a new refund against an
+// entry's gross amount and prior refunds.
+type cumulativeTotal struct {
+	total *big.Int // prior refunds + this refund
+	gross *big.Int
+}
+
+// cumulativeRefundAmount parses amount and entry's recorded gross/refunded
+// amounts as exact integers (token amounts are smallest-unit integers, not
+// floats) and checks that the running total does not exceed the gross
+// amount.
+func cumulativeRefundAmount(entry *LedgerEntry, amount string) (*cumulativeTotal, error) {
+	requested, ok := new(big.Int).SetString(amount, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid refund amount %q", amount)
+	}
+	if requested.Sign() <= 0 {
+		return nil, fmt.Errorf("refund amount %q must be positive", amount)
+	}
+	gross, ok := new(big.Int).SetString(entry.GrossAmount, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid recorded gross amount %q", entry.GrossAmount)
+	}
+	alreadyRefunded := big.NewInt(0)
+	if entry.RefundedAmount != "" {
+		alreadyRefunded, ok = new(big.Int).SetString(entry.RefundedAmount, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid recorded refunded amount %q", entry.RefundedAmount)
+		}
+	}
+
+	total := new(big.Int).Add(alreadyRefunded, requested)
+	if total.Cmp(gross) > 0 {
+		return nil, fmt.Errorf("refund of %s would exceed gross amount %s (already refunded %s)", amount, entry.GrossAmount, alreadyRefunded.String())
+	}
+	return &cumulativeTotal{total: total, gross: gross}, nil
+}
+
+// WithLedger configures the middleware to record every settled payment in
+// ledger, so it can be looked up or refunded later via RegisterLedgerRoutes.
+func WithLedger(ledger *Ledger) Option {
+	return func(o *Options) {
+		o.Ledger = ledger
+	}
+}
+
+// RegisterLedgerRoutes registers handlers for looking up a single historical
+// payment recorded in ledger (GET /signpay/payments/:tx) and refunding it
+// (POST /signpay/refunds). No route lists all recorded payments.
+func RegisterLedgerRoutes(r *gin.Engine, ledger *Ledger) {
+	r.GET("/signpay/payments/:tx", func(c *gin.Context) {
+		entry, err := ledger.store.Get(c.Request.Context(), c.Param("tx"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if entry == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
+			return
+		}
+		c.JSON(http.StatusOK, entry)
+	})
+
+	r.POST("/signpay/refunds", func(c *gin.Context) {
+		var req struct {
+			Transaction string `json:"transaction"`
+			Amount      string `json:"amount"`
+			Reason      string `json:"reason"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+			return
+		}
+
+		entry, err := ledger.Refund(c.Request.Context(), req.Transaction, req.Amount, req.Reason)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, entry)
+	})
+}
@@ -0,0 +1,171 @@
+package signpay
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// sqlLedgerStore is a LedgerStore backed by database/sql, shared by the
+// SQLite and Postgres adapters below. The two differ only in placeholder
+// syntax ("?" vs "$1") and schema creation.
+type sqlLedgerStore struct {
+	db         *sql.DB
+	bindVar    func(n int) string // returns the nth placeholder for this driver
+	createStmt string
+}
+
+func (s *sqlLedgerStore) migrate() error {
+	_, err := s.db.Exec(s.createStmt)
+	return err
+}
+
+func (s *sqlLedgerStore) Record(ctx context.Context, entry *LedgerEntry) error {
+	query := fmt.Sprintf(
+		`INSERT INTO signpay_ledger
+			(tx_hash, payer, payee, asset, network, gross_amount, timestamp, request_body, refund_state, refunded_amount, refund_reason)
+		 VALUES (%s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s)`,
+		s.bindVar(1), s.bindVar(2), s.bindVar(3), s.bindVar(4), s.bindVar(5),
+		s.bindVar(6), s.bindVar(7), s.bindVar(8), s.bindVar(9), s.bindVar(10), s.bindVar(11),
+	)
+	_, err := s.db.ExecContext(ctx, query,
+		entry.Transaction, entry.Payer, entry.Payee, entry.Asset, entry.Network,
+		entry.GrossAmount, entry.Timestamp, []byte(entry.RequestBody), string(RefundStateNone), "", "",
+	)
+	return err
+}
+
+func (s *sqlLedgerStore) Get(ctx context.Context, transaction string) (*LedgerEntry, error) {
+	query := fmt.Sprintf(
+		`SELECT tx_hash, payer, payee, asset, network, gross_amount, timestamp, request_body, refund_state, refunded_amount, refund_reason
+		 FROM signpay_ledger WHERE tx_hash = %s`, s.bindVar(1))
+	row := s.db.QueryRowContext(ctx, query, transaction)
+
+	entry, err := scanLedgerEntry(row.Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *sqlLedgerStore) List(ctx context.Context) ([]*LedgerEntry, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT tx_hash, payer, payee, asset, network, gross_amount, timestamp, request_body, refund_state, refunded_amount, refund_reason
+		 FROM signpay_ledger ORDER BY timestamp DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*LedgerEntry
+	for rows.Next() {
+		entry, err := scanLedgerEntry(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *sqlLedgerStore) UpdateRefund(ctx context.Context, transaction string, expectedRefundedAmount string, newRefundedAmount string, state RefundState, reason string) error {
+	query := fmt.Sprintf(
+		`UPDATE signpay_ledger SET refund_state = %s, refunded_amount = %s, refund_reason = %s WHERE tx_hash = %s AND refunded_amount = %s`,
+		s.bindVar(1), s.bindVar(2), s.bindVar(3), s.bindVar(4), s.bindVar(5))
+	result, err := s.db.ExecContext(ctx, query, string(state), newRefundedAmount, reason, transaction, expectedRefundedAmount)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	// No rows matched: either the payment doesn't exist, or its
+	// refunded_amount moved since the caller read it.
+	existing, err := s.Get(ctx, transaction)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return fmt.Errorf("payment %q not found", transaction)
+	}
+	return ErrRefundConflict
+}
+
+func scanLedgerEntry(scan func(dest ...any) error) (*LedgerEntry, error) {
+	var entry LedgerEntry
+	var requestBody []byte
+	var refundState string
+	if err := scan(
+		&entry.Transaction, &entry.Payer, &entry.Payee, &entry.Asset, &entry.Network,
+		&entry.GrossAmount, &entry.Timestamp, &requestBody, &refundState,
+		&entry.RefundedAmount, &entry.RefundReason,
+	); err != nil {
+		return nil, err
+	}
+	entry.RequestBody = json.RawMessage(requestBody)
+	entry.RefundState = RefundState(refundState)
+	return &entry, nil
+}
+
+// NewSQLiteLedgerStore returns a LedgerStore backed by db, which callers
+// should open with a SQLite driver (e.g. "github.com/mattn/go-sqlite3").
+// The ledger table is created if it does not already exist.
+func NewSQLiteLedgerStore(db *sql.DB) (LedgerStore, error) {
+	store := &sqlLedgerStore{
+		db:      db,
+		bindVar: func(n int) string { return "?" },
+		createStmt: `CREATE TABLE IF NOT EXISTS signpay_ledger (
+			tx_hash          TEXT PRIMARY KEY,
+			payer            TEXT NOT NULL,
+			payee            TEXT NOT NULL,
+			asset            TEXT NOT NULL,
+			network          TEXT NOT NULL,
+			gross_amount     TEXT NOT NULL,
+			timestamp        DATETIME NOT NULL,
+			request_body     BLOB,
+			refund_state     TEXT NOT NULL DEFAULT 'none',
+			refunded_amount  TEXT NOT NULL DEFAULT '',
+			refund_reason    TEXT NOT NULL DEFAULT ''
+		)`,
+	}
+	if err := store.migrate(); err != nil {
+		return nil, fmt.Errorf("migrate sqlite ledger store: %w", err)
+	}
+	return store, nil
+}
+
+// NewPostgresLedgerStore returns a LedgerStore backed by db, which callers
+// should open with a Postgres driver (e.g. "github.com/lib/pq"). The ledger
+// table is created if it does not already exist.
+func NewPostgresLedgerStore(db *sql.DB) (LedgerStore, error) {
+	store := &sqlLedgerStore{
+		db:      db,
+		bindVar: func(n int) string { return fmt.Sprintf("$%d", n) },
+		createStmt: `CREATE TABLE IF NOT EXISTS signpay_ledger (
+			tx_hash          TEXT PRIMARY KEY,
+			payer            TEXT NOT NULL,
+			payee            TEXT NOT NULL,
+			asset            TEXT NOT NULL,
+			network          TEXT NOT NULL,
+			gross_amount     TEXT NOT NULL,
+			timestamp        TIMESTAMPTZ NOT NULL,
+			request_body     BYTEA,
+			refund_state     TEXT NOT NULL DEFAULT 'none',
+			refunded_amount  TEXT NOT NULL DEFAULT '',
+			refund_reason    TEXT NOT NULL DEFAULT ''
+		)`,
+	}
+	if err := store.migrate(); err != nil {
+		return nil, fmt.Errorf("migrate postgres ledger store: %w", err)
+	}
+	return store, nil
+}
@@ -0,0 +1,204 @@
+package signpay
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coinbase/x402/go/pkg/types"
+)
+
+// NonceStore deduplicates settlement attempts so that a client resending the
+// same X-PAYMENT header (e.g. retrying after a network blip) results in a
+// single Verify/Settle round trip against the facilitator.
+//
+// Reserve should be called before Settle is attempted. If it returns
+// reserved=false, an attempt for key is already in flight or has already
+// completed; existing (if non-nil) holds the previously committed payment
+// data, which callers should serve in place of re-settling. Commit persists
+// the outcome of a reservation so future Reserve calls for the same key
+// return it. Release undoes a reservation that did not end in a commit
+// (e.g. verification failed) so a retried request is not locked out for the
+// full ttl; it must be a no-op once the key has been committed.
+type NonceStore interface {
+	Reserve(ctx context.Context, key string, ttl time.Duration) (reserved bool, existing *PaymentData, err error)
+	Commit(ctx context.Context, key string, data *PaymentData) error
+	Release(ctx context.Context, key string) error
+}
+
+// InMemoryNonceStore is a NonceStore backed by a process-local map. It is
+// suitable for a single instance; multi-instance deployments should use a
+// shared store such as Redis (see RedisNonceStore) so replays are caught
+// across the whole fleet.
+type InMemoryNonceStore struct {
+	mu      sync.Mutex
+	entries map[string]*nonceEntry
+}
+
+type nonceEntry struct {
+	data      *PaymentData // nil while the reservation is still in flight
+	expiresAt time.Time
+}
+
+// NewInMemoryNonceStore returns an empty InMemoryNonceStore.
+func NewInMemoryNonceStore() *InMemoryNonceStore {
+	return &InMemoryNonceStore{entries: make(map[string]*nonceEntry)}
+}
+
+func (s *InMemoryNonceStore) Reserve(ctx context.Context, key string, ttl time.Duration) (bool, *PaymentData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok && time.Now().Before(entry.expiresAt) {
+		return false, entry.data, nil
+	}
+
+	s.entries[key] = &nonceEntry{expiresAt: time.Now().Add(ttl)}
+	return true, nil, nil
+}
+
+func (s *InMemoryNonceStore) Commit(ctx context.Context, key string, data *PaymentData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return fmt.Errorf("nonce %q was never reserved", key)
+	}
+	entry.data = data
+	return nil
+}
+
+// Release removes an in-flight reservation for key so a future Reserve call
+// can retry it. It is a no-op if key was never reserved or has already been
+// committed, so a completed settlement is never erased from the dedupe
+// window.
+func (s *InMemoryNonceStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok && entry.data == nil {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+// RedisClient is the subset of a Redis client that RedisNonceStore needs.
+// Its methods return plain values rather than go-redis command objects, so
+// github.com/redis/go-redis/v9's *redis.Client satisfies it only through a
+// thin wrapper.
+type RedisClient interface {
+	// SetNX sets key to value with the given TTL only if key does not already
+	// exist, returning whether the set happened.
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	// Get returns the value stored at key, or (nil, false) if it is unset.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set overwrites key with value, preserving its existing TTL.
+	Set(ctx context.Context, key string, value []byte) error
+	// Del removes key. It is not an error for key to already be unset.
+	Del(ctx context.Context, key string) error
+}
+
+// RedisNonceStore is a NonceStore backed by a shared Redis instance, so that
+// replay protection holds across every instance behind a load balancer.
+type RedisNonceStore struct {
+	client RedisClient
+	prefix string
+}
+
+// NewRedisNonceStore returns a RedisNonceStore that namespaces its keys under
+// prefix (e.g. "signpay:nonce:").
+func NewRedisNonceStore(client RedisClient, prefix string) *RedisNonceStore {
+	return &RedisNonceStore{client: client, prefix: prefix}
+}
+
+func (s *RedisNonceStore) Reserve(ctx context.Context, key string, ttl time.Duration) (bool, *PaymentData, error) {
+	redisKey := s.prefix + key
+	reserved, err := s.client.SetNX(ctx, redisKey, nil, ttl)
+	if err != nil {
+		return false, nil, fmt.Errorf("reserve nonce: %w", err)
+	}
+	if reserved {
+		return true, nil, nil
+	}
+
+	raw, ok, err := s.client.Get(ctx, redisKey)
+	if err != nil {
+		return false, nil, fmt.Errorf("load existing nonce: %w", err)
+	}
+	if !ok || len(raw) == 0 {
+		// Reservation exists but Commit hasn't landed yet.
+		return false, nil, nil
+	}
+
+	data, err := decodePaymentData(raw)
+	if err != nil {
+		return false, nil, fmt.Errorf("decode cached payment data: %w", err)
+	}
+	return false, data, nil
+}
+
+func (s *RedisNonceStore) Commit(ctx context.Context, key string, data *PaymentData) error {
+	raw, err := encodePaymentData(data)
+	if err != nil {
+		return fmt.Errorf("encode payment data: %w", err)
+	}
+	return s.client.Set(ctx, s.prefix+key, raw)
+}
+
+// Release removes an in-flight reservation for key so a future Reserve call
+// can retry it. It is a no-op if key was never reserved or has already been
+// committed, so a completed settlement is never erased from the dedupe
+// window.
+func (s *RedisNonceStore) Release(ctx context.Context, key string) error {
+	redisKey := s.prefix + key
+	raw, ok, err := s.client.Get(ctx, redisKey)
+	if err != nil {
+		return fmt.Errorf("load nonce before release: %w", err)
+	}
+	if !ok || len(raw) > 0 {
+		// Already released, or already committed - leave it alone.
+		return nil
+	}
+	return s.client.Del(ctx, redisKey)
+}
+
+// settlementNonceKey derives the idempotency key for a payment attempt from
+// the ERC-3009 authorization nonce plus the signer, asset, and chain it was
+// scoped to, so the same nonce replayed against a different asset or chain
+// does not collide.
+func settlementNonceKey(payload *types.PaymentPayload, requirements *types.PaymentRequirements) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%s|%s|%s",
+		payload.Payload.Authorization.Nonce,
+		payload.Payload.Authorization.From,
+		requirements.Asset,
+		requirements.Network,
+	)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// defaultNonceTTL bounds how long a settlement attempt is remembered for
+// replay detection; it should comfortably exceed the facilitator's own
+// settlement timeout.
+const defaultNonceTTL = 10 * time.Minute
+
+// encodePaymentData/decodePaymentData let a NonceStore persist the committed
+// PaymentData for a settled nonce (e.g. as a Redis value) so a replayed
+// request can be served the original response without serializing the whole
+// PaymentData type by hand.
+func encodePaymentData(data *PaymentData) ([]byte, error) {
+	return json.Marshal(data)
+}
+
+func decodePaymentData(raw []byte) (*PaymentData, error) {
+	var data PaymentData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
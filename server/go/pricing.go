@@ -0,0 +1,147 @@
+package signpay
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/coinbase/x402/go/pkg/types"
+)
+
+// PriceSpec describes a payment amount in fiat terms (e.g. "$9.99") that a
+// PriceOracle converts into the token-denominated MaxAmountRequired for a
+// specific AcceptedPayment at request time.
+type PriceSpec struct {
+	Currency string // ISO 4217 code, e.g. "USD"
+	Amount   float64
+}
+
+// AcceptedPayment describes one (chain, asset, recipient) combination the
+// middleware is willing to accept. Exactly one of TokenAmount or Price should
+// be set: TokenAmount fixes the amount in the smallest token unit, while
+// Price defers to the configured PriceOracle to compute it per request.
+type AcceptedPayment struct {
+	ChainID          int
+	TokenAddress     string
+	TokenAmount      string // smallest token units; ignored if Price is set
+	RecipientAddress string
+	Price            *PriceSpec
+}
+
+// PriceOracle converts a fiat PriceSpec into the smallest-unit token amount
+// required for a given AcceptedPayment.
+type PriceOracle interface {
+	Quote(ctx context.Context, payment AcceptedPayment, spec PriceSpec) (tokenAmount string, err error)
+}
+
+// DefaultPriceOracle is a USD-pegged PriceOracle that assumes the quoted
+// asset is a stablecoin with Decimals decimal places (6 for USDC). It is
+// intended for demos and simple deployments; production integrations should
+// provide an oracle backed by a live feed such as Chainlink or CoinGecko.
+type DefaultPriceOracle struct {
+	Decimals int
+}
+
+// NewDefaultPriceOracle returns a DefaultPriceOracle assuming decimals
+// decimal places (e.g. 6 for USDC).
+func NewDefaultPriceOracle(decimals int) *DefaultPriceOracle {
+	return &DefaultPriceOracle{Decimals: decimals}
+}
+
+func (o *DefaultPriceOracle) Quote(ctx context.Context, payment AcceptedPayment, spec PriceSpec) (string, error) {
+	if spec.Currency != "USD" {
+		return "", fmt.Errorf("DefaultPriceOracle only supports USD, got %q", spec.Currency)
+	}
+	units := spec.Amount
+	for i := 0; i < o.Decimals; i++ {
+		units *= 10
+	}
+	return fmt.Sprintf("%d", int64(units+0.5)), nil
+}
+
+// resolveAcceptedPayments returns the full list of AcceptedPayment the
+// middleware should advertise: the primary (chainId, tokenAddress,
+// tokenAmount, recipientAddress) configured on SignPayMiddleware, followed by
+// any additional payments registered via WithAcceptedPayments. The primary is
+// left out when it has neither a TokenAmount nor a Price.
+func resolveAcceptedPayments(primary AcceptedPayment, additional []AcceptedPayment) []AcceptedPayment {
+	payments := make([]AcceptedPayment, 0, len(additional)+1)
+	if primary.TokenAmount != "" || primary.Price != nil {
+		payments = append(payments, primary)
+	}
+	return append(payments, additional...)
+}
+
+// buildPaymentRequirements converts payment into types.PaymentRequirements,
+// consulting oracle for the MaxAmountRequired when payment.Price is set.
+func buildPaymentRequirements(ctx context.Context, payment AcceptedPayment, resourceURL string, oracle PriceOracle) (*types.PaymentRequirements, error) {
+	network, ok := chainIDToNetwork[payment.ChainID]
+	if !ok {
+		return nil, fmt.Errorf("unsupported chain ID: %d", payment.ChainID)
+	}
+
+	amount := payment.TokenAmount
+	if payment.Price != nil {
+		if oracle == nil {
+			return nil, fmt.Errorf("payment requires a PriceOracle but none is configured")
+		}
+		quoted, err := oracle.Quote(ctx, payment, *payment.Price)
+		if err != nil {
+			return nil, fmt.Errorf("quote price: %w", err)
+		}
+		amount = quoted
+	}
+
+	return &types.PaymentRequirements{
+		Scheme:            "exact",
+		Network:           network,
+		MaxAmountRequired: amount,
+		Resource:          resourceURL,
+		Description:       "Payment for purchase",
+		PayTo:             payment.RecipientAddress,
+		Asset:             payment.TokenAddress,
+		MaxTimeoutSeconds: 300, // 5 minutes default timeout
+		Extra:             nil,
+	}, nil
+}
+
+// selectPaymentRequirements picks the PaymentRequirements matching the
+// network the caller's X-PAYMENT payload was signed for. With a single
+// configured AcceptedPayment this always returns that one requirement. The
+// payload itself carries no asset address, so when several accepted assets
+// share a network it narrows by the recipient the payload actually pays
+// (requirements.PayTo); if that's still ambiguous, settling against the
+// wrong asset is worse than failing closed, so it errors instead of
+// guessing.
+func selectPaymentRequirements(payload *types.PaymentPayload, all []*types.PaymentRequirements) (*types.PaymentRequirements, error) {
+	if len(all) == 1 {
+		return all[0], nil
+	}
+
+	var onNetwork []*types.PaymentRequirements
+	for _, requirements := range all {
+		if requirements.Network == payload.Network {
+			onNetwork = append(onNetwork, requirements)
+		}
+	}
+	if len(onNetwork) == 0 {
+		return nil, fmt.Errorf("no accepted payment matches network %q", payload.Network)
+	}
+	if len(onNetwork) == 1 {
+		return onNetwork[0], nil
+	}
+
+	payTo := payload.Payload.Authorization.To
+	var onNetworkAndPayTo []*types.PaymentRequirements
+	for _, requirements := range onNetwork {
+		if requirements.PayTo == payTo {
+			onNetworkAndPayTo = append(onNetworkAndPayTo, requirements)
+		}
+	}
+	if len(onNetworkAndPayTo) == 1 {
+		return onNetworkAndPayTo[0], nil
+	}
+	if len(onNetworkAndPayTo) == 0 {
+		return nil, fmt.Errorf("no accepted payment on network %q pays recipient %q", payload.Network, payTo)
+	}
+	return nil, fmt.Errorf("ambiguous payment: %d accepted assets on network %q share recipient %q; configure distinct recipients or a single asset per network", len(onNetworkAndPayTo), payload.Network, payTo)
+}
@@ -8,11 +8,8 @@ package signpay
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"io"
-	"net/http"
 
-	"github.com/coinbase/x402/go/pkg/facilitatorclient"
 	"github.com/coinbase/x402/go/pkg/types"
 	"github.com/gin-gonic/gin"
 )
@@ -47,6 +44,8 @@ type PaymentData struct {
 	PaymentRequirements *types.PaymentRequirements
 	VerifyResponse      *types.VerifyResponse
 	RequestBody         json.RawMessage // Raw JSON from request body
+	IdempotencyKey      string          // value of the Idempotency-Key request header, if any
+	Replayed            bool            // true if this PaymentData was served from the NonceStore instead of a fresh settlement
 }
 
 // UnmarshalOrderData unmarshals the request body into the provided struct
@@ -59,8 +58,18 @@ func (p *PaymentData) UnmarshalOrderData(v interface{}) error {
 
 // Options contains configuration for the payment middleware
 type Options struct {
-	APIKey   string
-	Resource string
+	APIKey      string
+	Resource    string
+	APIKeyStore APIKeyStore // if set, callers must present a valid X-API-Key before facilitator checks run
+	NonceStore  NonceStore  // if set, duplicate settlement attempts are deduped instead of re-settled
+
+	AdditionalAccepts []AcceptedPayment // extra (chain, asset, recipient) combinations advertised alongside the primary one
+	PriceOracle       PriceOracle       // required if any AcceptedPayment uses a Price instead of a fixed TokenAmount
+
+	EventSink EventSink // if set, receives a lifecycle event at each stage of processing
+	Ledger    *Ledger   // if set, every settled payment is recorded for later lookup/refund
+
+	Logger Logger // if set, receives soft-failure diagnostics instead of the default stdlib logger
 }
 
 // Option is a functional option for configuring the middleware
@@ -73,6 +82,35 @@ func WithAPIKey(apiKey string) Option {
 	}
 }
 
+// WithNonceStore configures the middleware to dedupe settlement attempts
+// using store, keyed off the ERC-3009 authorization nonce, signer, asset, and
+// chain. A duplicate request skips Settle entirely and is handed the
+// PaymentData committed by the original attempt. A duplicate that arrives
+// while the original attempt is still in flight is rejected with 409 Conflict.
+func WithNonceStore(store NonceStore) Option {
+	return func(o *Options) {
+		o.NonceStore = store
+	}
+}
+
+// WithAcceptedPayments registers additional (chain, asset, recipient)
+// combinations the middleware should advertise in the 402 `accepts` array
+// alongside the primary one configured on SignPayMiddleware. The wallet's
+// X-PAYMENT payload determines which one is actually settled.
+func WithAcceptedPayments(payments ...AcceptedPayment) Option {
+	return func(o *Options) {
+		o.AdditionalAccepts = append(o.AdditionalAccepts, payments...)
+	}
+}
+
+// WithPriceOracle configures the PriceOracle used to convert any
+// AcceptedPayment's Price into a token-denominated MaxAmountRequired.
+func WithPriceOracle(oracle PriceOracle) Option {
+	return func(o *Options) {
+		o.PriceOracle = oracle
+	}
+}
+
 // WithResource sets a custom resource URL for the payment requirements.
 // If not provided, the resource URL is automatically constructed from the request.
 func WithResource(resource string) Option {
@@ -92,54 +130,44 @@ func GetPaymentData(c *gin.Context) *PaymentData {
 // under the key "signPaymentData" and can be accessed via c.Get(signpay.PaymentDataKey).
 // The request body is also captured and included in PaymentData.RequestBody.
 //
+// This is a thin Gin adapter around Verifier, which holds the actual
+// verification and settlement logic; see NewVerifier for other framework
+// adapters (Handler for net/http, EchoMiddleware, UnaryServerInterceptor).
+//
 // Parameters:
 //   - chainId: The blockchain network chain ID (e.g., 8453 for Base, 84532 for Base Sepolia)
 //   - tokenAddress: The ERC-3009 token contract address
 //   - tokenAmount: The payment amount in smallest token units (e.g., "19990000" for 19.99 USDC with 6 decimals)
 //   - recipientAddress: The recipient address for payments
 //   - facilitatorURL: The URL of the x402 facilitator service
-//   - opts: Optional configuration options (e.g., WithAPIKey for facilitator authentication)
+//   - opts: Optional configuration options (e.g., WithAPIKey for facilitator authentication,
+//     WithAPIKeyStore to require and rate-limit a caller-supplied X-API-Key,
+//     WithNonceStore to dedupe replayed settlement attempts, WithAcceptedPayments
+//     to advertise additional chain/asset combinations, WithPriceOracle to
+//     quote fiat-denominated AcceptedPayment prices, WithEventSink to publish
+//     settlement lifecycle events, WithLedger to record settled payments for
+//     later lookup and refund)
 func SignPayMiddleware(chainId int, tokenAddress string, tokenAmount string, recipientAddress string, facilitatorURL string, opts ...Option) gin.HandlerFunc {
-	// Apply options
-	options := &Options{}
-	for _, opt := range opts {
-		opt(options)
-	}
-
-	// Get network name from chain ID
-	network, ok := chainIDToNetwork[chainId]
-	if !ok {
-		panic(fmt.Sprintf("unsupported chain ID: %d", chainId))
-	}
-
-	// Configure facilitator client
-	facilitatorConfig := &types.FacilitatorConfig{
-		URL: facilitatorURL,
-	}
+	verifier := NewVerifier(chainId, tokenAddress, tokenAmount, recipientAddress, facilitatorURL, opts...)
+	options := verifier.options
 
-	// Add API key authentication if configured
-	if options.APIKey != "" {
-		apiKey := options.APIKey
-		facilitatorConfig.CreateAuthHeaders = func() (map[string]map[string]string, error) {
-			authHeader := map[string]string{
-				"Authorization": "Bearer " + apiKey,
+	return func(c *gin.Context) {
+		// Caller-level auth (API key, rate limit, domain/IP allowlists) happens
+		// before we spend a facilitator round trip on the request.
+		if options.APIKeyStore != nil {
+			if !checkAPIKey(c, options.APIKeyStore) {
+				return
 			}
-			return map[string]map[string]string{
-				"verify": authHeader,
-				"settle": authHeader,
-			}, nil
 		}
-	}
 
-	facilitatorClient := facilitatorclient.NewFacilitatorClient(facilitatorConfig)
+		idempotencyKey := c.GetHeader("Idempotency-Key")
 
-	return func(c *gin.Context) {
 		// Read and preserve request body
 		var requestBody json.RawMessage
 		if c.Request.Body != nil {
 			bodyBytes, err := io.ReadAll(c.Request.Body)
 			if err != nil {
-				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				c.AbortWithStatusJSON(400, gin.H{
 					"error": "Failed to read request body",
 				})
 				return
@@ -152,127 +180,36 @@ func SignPayMiddleware(chainId int, tokenAddress string, tokenAmount string, rec
 			}
 		}
 
-		// Create payment requirements for verification
-		// Determine resource URL (use provided or auto-construct)
-		var resourceURL string
-		if options.Resource != "" {
-			resourceURL = options.Resource
-		} else {
-			// Auto-construct resource URL from request
-			scheme := "http"
-			if c.Request.TLS != nil {
-				scheme = "https"
-			}
-			resourceURL = fmt.Sprintf("%s://%s%s", scheme, c.Request.Host, c.Request.URL.Path)
-		}
-
-		// Determine payment amount (context overrides configured amount)
-		amount := tokenAmount
-		if dynamicAmount, exists := c.Get("signpay:amount"); exists {
-			amount = dynamicAmount.(string)
-		}
-
-		// Validate amount is configured
-		if amount == "" {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-				"error":       "Payment amount not configured. Set amount parameter or use c.Set(\"signpay:amount\", amount) in preceding middleware.",
-				"x402Version": x402Version,
-			})
-			return
-		}
-
-		paymentRequirements := &types.PaymentRequirements{
-			Scheme:            "exact",
-			Network:           network,
-			MaxAmountRequired: amount,
-			Resource:          resourceURL,
-			Description:       "Payment for purchase",
-			PayTo:             recipientAddress,
-			Asset:             tokenAddress,
-			MaxTimeoutSeconds: 300, // 5 minutes default timeout
-			Extra:             nil,
-		}
-
-		payment := c.GetHeader("X-PAYMENT")
-		if payment == "" {
-			c.AbortWithStatusJSON(http.StatusPaymentRequired, gin.H{
-				"error":       "X-PAYMENT header is required",
-				"accepts":     []*types.PaymentRequirements{paymentRequirements},
-				"x402Version": x402Version,
-			})
-			return
-		}
-
-		paymentPayload, err := types.DecodePaymentPayloadFromBase64(payment)
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-				"error":       "Invalid payment payload: " + err.Error(),
-				"x402Version": x402Version,
-			})
-			return
-		}
-		paymentPayload.X402Version = x402Version
-
-		// Verify payment
-		verifyResponse, err := facilitatorClient.Verify(paymentPayload, paymentRequirements)
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-				"error":       "Payment verification failed: " + err.Error(),
-				"x402Version": x402Version,
-			})
-			return
-		}
-
-		if !verifyResponse.IsValid {
-			reason := "unknown reason"
-			if verifyResponse.InvalidReason != nil {
-				reason = *verifyResponse.InvalidReason
-			}
-			c.AbortWithStatusJSON(http.StatusPaymentRequired, gin.H{
-				"error":       "Payment verification failed: " + reason,
-				"accepts":     []*types.PaymentRequirements{paymentRequirements},
-				"x402Version": x402Version,
-			})
-			return
+		// Context overrides the configured amount (e.g. a preceding pricing
+		// middleware computing a dynamic order total).
+		var dynamicAmount string
+		if amount, exists := c.Get("signpay:amount"); exists {
+			dynamicAmount = amount.(string)
 		}
 
-		// Settle payment
-		settleResponse, err := facilitatorClient.Settle(paymentPayload, paymentRequirements)
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-				"error":       "Payment settlement failed: " + err.Error(),
-				"x402Version": x402Version,
-			})
-			return
+		in := RequirementsInput{
+			Method:        c.Request.Method,
+			Path:          c.Request.URL.Path,
+			Host:          c.Request.Host,
+			TLS:           c.Request.TLS != nil,
+			DynamicAmount: dynamicAmount,
 		}
 
-		if !settleResponse.Success {
-			errorReason := "Settlement was not successful"
-			if settleResponse.ErrorReason != nil {
-				errorReason = *settleResponse.ErrorReason
+		paymentHeader := c.GetHeader("X-PAYMENT")
+		paymentData, paymentErr := verifier.HandlePayment(c.Request.Context(), in, paymentHeader, requestBody, idempotencyKey)
+		if paymentErr != nil {
+			body := gin.H{"error": paymentErr.Message, "x402Version": x402Version}
+			if paymentErr.Accepts != nil {
+				body["accepts"] = paymentErr.Accepts
 			}
-			c.AbortWithStatusJSON(http.StatusPaymentRequired, gin.H{
-				"error":       "Payment settlement failed: " + errorReason,
-				"accepts":     []*types.PaymentRequirements{paymentRequirements},
-				"x402Version": x402Version,
-			})
+			c.AbortWithStatusJSON(paymentErr.StatusCode, body)
 			return
 		}
 
-		// Add X-PAYMENT-RESPONSE header
-		settleResponseHeader, err := settleResponse.EncodeToBase64String()
-		if err == nil {
+		if settleResponseHeader, err := verifier.EncodeResponseHeader(paymentData); err == nil {
 			c.Header("X-PAYMENT-RESPONSE", settleResponseHeader)
 		}
 
-		// Store payment data in context for handler access
-		paymentData := &PaymentData{
-			PaymentPayload:      paymentPayload,
-			SettleResponse:      settleResponse,
-			PaymentRequirements: paymentRequirements,
-			VerifyResponse:      verifyResponse,
-			RequestBody:         requestBody,
-		}
 		c.Set(PaymentDataKey, paymentData)
 
 		// Continue to next handler
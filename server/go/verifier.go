@@ -0,0 +1,303 @@
+package signpay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/coinbase/x402/go/pkg/facilitatorclient"
+	"github.com/coinbase/x402/go/pkg/types"
+)
+
+// Logger receives diagnostic messages for operations that fail without
+// aborting the request, e.g. a nonce release/commit or ledger record after
+// verification or settlement has already run. The standard library's
+// *log.Logger satisfies this, as does most structured loggers via a small
+// adapter.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// WithLogger configures the logger used for soft-failure diagnostics that
+// would otherwise go to the standard library's default logger. Defaults to
+// log.Default(), which writes to standard error.
+func WithLogger(logger Logger) Option {
+	return func(o *Options) {
+		o.Logger = logger
+	}
+}
+
+// RequirementsInput carries the framework-agnostic request details
+// Verifier.BuildRequirements and Verifier.HandlePayment need, so Verifier
+// has no dependency on any particular HTTP router.
+type RequirementsInput struct {
+	Method        string // request method, e.g. "POST"
+	Path          string // request URL path
+	Host          string // request Host header
+	Resource      string // full resource URL; auto-constructed from Host/Path/TLS if empty
+	TLS           bool   // whether the request arrived over TLS, used to construct Resource
+	DynamicAmount string // overrides the configured token amount, e.g. set by a pricing middleware
+}
+
+// PaymentError is returned by Verifier.HandlePayment when a request cannot
+// proceed. Adapters translate it into their framework's error response,
+// using StatusCode and Accepts the same way the original Gin middleware did.
+type PaymentError struct {
+	StatusCode int
+	Message    string
+	Accepts    []*types.PaymentRequirements
+}
+
+func (e *PaymentError) Error() string {
+	return e.Message
+}
+
+// Verifier holds the framework-neutral payment verification and settlement
+// logic shared by every adapter (Gin, net/http, Echo, gRPC). SignPayMiddleware
+// is now a thin Gin wrapper around a Verifier; new adapters should be too.
+type Verifier struct {
+	chainId           int
+	tokenAddress      string
+	tokenAmount       string
+	recipientAddress  string
+	options           *Options
+	facilitatorClient *facilitatorclient.FacilitatorClient
+}
+
+// NewVerifier builds a Verifier from the same parameters SignPayMiddleware
+// has always accepted, so every adapter shares one source of truth for
+// facilitator configuration and option handling.
+func NewVerifier(chainId int, tokenAddress string, tokenAmount string, recipientAddress string, facilitatorURL string, opts ...Option) *Verifier {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	if _, ok := chainIDToNetwork[chainId]; !ok {
+		panic(fmt.Sprintf("unsupported chain ID: %d", chainId))
+	}
+
+	facilitatorConfig := &types.FacilitatorConfig{URL: facilitatorURL}
+	if options.APIKey != "" {
+		apiKey := options.APIKey
+		facilitatorConfig.CreateAuthHeaders = func() (map[string]map[string]string, error) {
+			authHeader := map[string]string{"Authorization": "Bearer " + apiKey}
+			return map[string]map[string]string{"verify": authHeader, "settle": authHeader}, nil
+		}
+	}
+
+	return &Verifier{
+		chainId:           chainId,
+		tokenAddress:      tokenAddress,
+		tokenAmount:       tokenAmount,
+		recipientAddress:  recipientAddress,
+		options:           options,
+		facilitatorClient: facilitatorclient.NewFacilitatorClient(facilitatorConfig),
+	}
+}
+
+// resourceURL returns the configured Resource override, or auto-constructs
+// one from in.
+func (v *Verifier) resourceURL(in RequirementsInput) string {
+	if v.options.Resource != "" {
+		return v.options.Resource
+	}
+	if in.Resource != "" {
+		return in.Resource
+	}
+	scheme := "http"
+	if in.TLS {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, in.Host, in.Path)
+}
+
+// BuildRequirements returns the PaymentRequirements for every asset the
+// Verifier is willing to accept for the request described by in.
+func (v *Verifier) BuildRequirements(ctx context.Context, in RequirementsInput) ([]*types.PaymentRequirements, error) {
+	amount := v.tokenAmount
+	if in.DynamicAmount != "" {
+		amount = in.DynamicAmount
+	}
+
+	primary := AcceptedPayment{
+		ChainID:          v.chainId,
+		TokenAddress:     v.tokenAddress,
+		TokenAmount:      amount,
+		RecipientAddress: v.recipientAddress,
+	}
+	acceptedPayments := resolveAcceptedPayments(primary, v.options.AdditionalAccepts)
+	if len(acceptedPayments) == 0 {
+		return nil, fmt.Errorf("payment amount not configured: set tokenAmount, in.DynamicAmount, or WithAcceptedPayments")
+	}
+
+	resourceURL := v.resourceURL(in)
+	requirements := make([]*types.PaymentRequirements, 0, len(acceptedPayments))
+	for _, payment := range acceptedPayments {
+		r, err := buildPaymentRequirements(ctx, payment, resourceURL, v.options.PriceOracle)
+		if err != nil {
+			return nil, err
+		}
+		requirements = append(requirements, r)
+	}
+	return requirements, nil
+}
+
+// HandlePayment runs nonce dedupe, verification, and settlement for a single
+// request given its X-PAYMENT header value and raw body. Caller-level auth
+// (e.g. an API key check) is adapter-specific and must happen before this is
+// called. On success it returns the resulting PaymentData; on failure it
+// returns a PaymentError describing the response the adapter should send.
+func (v *Verifier) HandlePayment(ctx context.Context, in RequirementsInput, paymentHeader string, requestBody json.RawMessage, idempotencyKey string) (*PaymentData, *PaymentError) {
+	allRequirements, err := v.BuildRequirements(ctx, in)
+	if err != nil {
+		v.emit(ctx, in, EventPaymentFailed, nil, "Failed to build payment requirements: "+err.Error())
+		return nil, &PaymentError{StatusCode: http.StatusInternalServerError, Message: "Failed to build payment requirements: " + err.Error()}
+	}
+
+	if paymentHeader == "" {
+		v.emit(ctx, in, EventPaymentRequired, nil, "")
+		return nil, &PaymentError{StatusCode: http.StatusPaymentRequired, Message: "X-PAYMENT header is required", Accepts: allRequirements}
+	}
+
+	paymentPayload, err := types.DecodePaymentPayloadFromBase64(paymentHeader)
+	if err != nil {
+		v.emit(ctx, in, EventPaymentFailed, nil, "Invalid payment payload: "+err.Error())
+		return nil, &PaymentError{StatusCode: http.StatusBadRequest, Message: "Invalid payment payload: " + err.Error()}
+	}
+	paymentPayload.X402Version = x402Version
+
+	paymentRequirements, err := selectPaymentRequirements(paymentPayload, allRequirements)
+	if err != nil {
+		v.emit(ctx, in, EventPaymentFailed, nil, err.Error())
+		return nil, &PaymentError{StatusCode: http.StatusBadRequest, Message: err.Error(), Accepts: allRequirements}
+	}
+
+	var nonceKey string
+	if v.options.NonceStore != nil {
+		nonceKey = settlementNonceKey(paymentPayload, paymentRequirements)
+		reserved, existing, err := v.options.NonceStore.Reserve(ctx, nonceKey, defaultNonceTTL)
+		if err != nil {
+			v.emit(ctx, in, EventPaymentFailed, nil, "Failed to check payment nonce: "+err.Error())
+			return nil, &PaymentError{StatusCode: http.StatusInternalServerError, Message: "Failed to check payment nonce: " + err.Error()}
+		}
+		if !reserved {
+			if existing == nil {
+				v.emit(ctx, in, EventPaymentFailed, nil, "A settlement attempt for this payment is already in progress")
+				return nil, &PaymentError{StatusCode: http.StatusConflict, Message: "A settlement attempt for this payment is already in progress"}
+			}
+			// Copy so the committed PaymentData, which the original request
+			// may still hold, keeps Replayed=false.
+			replayed := *existing
+			replayed.Replayed = true
+			v.emit(ctx, in, EventPaymentReplayed, &replayed, "")
+			return &replayed, nil
+		}
+	}
+
+	verifyResponse, err := v.facilitatorClient.Verify(paymentPayload, paymentRequirements)
+	if err != nil {
+		v.releaseNonce(ctx, nonceKey)
+		v.emit(ctx, in, EventPaymentFailed, nil, "Payment verification failed: "+err.Error())
+		return nil, &PaymentError{StatusCode: http.StatusInternalServerError, Message: "Payment verification failed: " + err.Error()}
+	}
+	if !verifyResponse.IsValid {
+		reason := "unknown reason"
+		if verifyResponse.InvalidReason != nil {
+			reason = *verifyResponse.InvalidReason
+		}
+		v.releaseNonce(ctx, nonceKey)
+		v.emit(ctx, in, EventPaymentFailed, nil, "Payment verification failed: "+reason)
+		return nil, &PaymentError{StatusCode: http.StatusPaymentRequired, Message: "Payment verification failed: " + reason, Accepts: allRequirements}
+	}
+	v.emit(ctx, in, EventPaymentVerified, &PaymentData{
+		PaymentPayload:      paymentPayload,
+		PaymentRequirements: paymentRequirements,
+		VerifyResponse:      verifyResponse,
+	}, "")
+
+	settleResponse, err := v.facilitatorClient.Settle(paymentPayload, paymentRequirements)
+	if err != nil {
+		v.releaseNonce(ctx, nonceKey)
+		v.emit(ctx, in, EventPaymentFailed, nil, "Payment settlement failed: "+err.Error())
+		return nil, &PaymentError{StatusCode: http.StatusInternalServerError, Message: "Payment settlement failed: " + err.Error()}
+	}
+	if !settleResponse.Success {
+		errorReason := "Settlement was not successful"
+		if settleResponse.ErrorReason != nil {
+			errorReason = *settleResponse.ErrorReason
+		}
+		v.releaseNonce(ctx, nonceKey)
+		v.emit(ctx, in, EventPaymentFailed, nil, "Payment settlement failed: "+errorReason)
+		return nil, &PaymentError{StatusCode: http.StatusPaymentRequired, Message: "Payment settlement failed: " + errorReason, Accepts: allRequirements}
+	}
+
+	paymentData := &PaymentData{
+		PaymentPayload:      paymentPayload,
+		SettleResponse:      settleResponse,
+		PaymentRequirements: paymentRequirements,
+		VerifyResponse:      verifyResponse,
+		RequestBody:         requestBody,
+		IdempotencyKey:      idempotencyKey,
+	}
+
+	if v.options.NonceStore != nil {
+		if err := v.options.NonceStore.Commit(ctx, nonceKey, paymentData); err != nil {
+			v.logger().Printf("[SignPay] failed to commit nonce %s: %v", nonceKey, err)
+		}
+	}
+	if v.options.Ledger != nil {
+		if err := v.options.Ledger.Record(ctx, paymentData); err != nil {
+			v.logger().Printf("[SignPay] failed to record ledger entry for %s: %v", settleResponse.Transaction, err)
+		}
+	}
+	v.emit(ctx, in, EventPaymentSettled, paymentData, "")
+
+	return paymentData, nil
+}
+
+// releaseNonce undoes a NonceStore reservation for a request that failed
+// before reaching Commit, so a client retrying after a transient facilitator
+// error is not locked out of Reserve for the rest of defaultNonceTTL.
+func (v *Verifier) releaseNonce(ctx context.Context, nonceKey string) {
+	if v.options.NonceStore == nil || nonceKey == "" {
+		return
+	}
+	if err := v.options.NonceStore.Release(ctx, nonceKey); err != nil {
+		v.logger().Printf("[SignPay] failed to release nonce %s: %v", nonceKey, err)
+	}
+}
+
+// logger returns the configured Logger, or the standard library's default
+// logger if none was set via WithLogger.
+func (v *Verifier) logger() Logger {
+	if v.options.Logger != nil {
+		return v.options.Logger
+	}
+	return log.Default()
+}
+
+// EncodeResponseHeader encodes data's SettleResponse as the value for the
+// X-PAYMENT-RESPONSE header.
+func (v *Verifier) EncodeResponseHeader(data *PaymentData) (string, error) {
+	return data.SettleResponse.EncodeToBase64String()
+}
+
+func (v *Verifier) emit(ctx context.Context, in RequirementsInput, eventType EventType, data *PaymentData, errMsg string) {
+	if v.options.EventSink == nil {
+		return
+	}
+	v.options.EventSink.Emit(ctx, Event{
+		Type:        eventType,
+		Sequence:    nextEventSequence(),
+		PaymentData: data,
+		Method:      in.Method,
+		Path:        in.Path,
+		Host:        in.Host,
+		Error:       errMsg,
+		Timestamp:   time.Now(),
+	})
+}